@@ -0,0 +1,211 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+	"path"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	DefaultAnnotationKeyDomain = "k8s-manifest-sigstore"
+	ImageRefAnnotationBaseName = "image-ref"
+)
+
+// AnnotationConfig customizes the annotation key domain used to read/write this
+// package's own annotations (image-ref, compare-options, ...), e.g. for a fork or a
+// private deployment that wants its own prefix instead of "k8s-manifest-sigstore/...".
+type AnnotationConfig struct {
+	AnnotationKeyDomain string `json:"annotationKeyDomain,omitempty"`
+}
+
+func (ac AnnotationConfig) domain() string {
+	if ac.AnnotationKeyDomain == "" {
+		return DefaultAnnotationKeyDomain
+	}
+	return ac.AnnotationKeyDomain
+}
+
+// ImageRefAnnotationKey is the annotation key a resource's image reference is read from
+// when VerifyResourceOption.ImageRef is not set directly.
+func (ac AnnotationConfig) ImageRefAnnotationKey() string {
+	return fmt.Sprintf("%s/%s", ac.domain(), ImageRefAnnotationBaseName)
+}
+
+// CompareOptionsAnnotationKey is the annotation key a resource's per-resource
+// compare-options are read from.
+func (ac AnnotationConfig) CompareOptionsAnnotationKey() string {
+	return fmt.Sprintf("%s/%s", ac.domain(), CompareOptionsAnnotationBaseName)
+}
+
+// ObjectGlobSelector matches a resource by kind/name/namespace, where each non-empty
+// field is a shell glob pattern (see path.Match) rather than an exact string. An empty
+// field matches anything.
+type ObjectGlobSelector struct {
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Matches reports whether kind/name/namespace all satisfy this selector's glob patterns.
+func (s ObjectGlobSelector) Matches(kind, name, namespace string) bool {
+	if s.Kind != "" && !globMatch(s.Kind, kind) {
+		return false
+	}
+	if s.Name != "" && !globMatch(s.Name, name) {
+		return false
+	}
+	if s.Namespace != "" && !globMatch(s.Namespace, namespace) {
+		return false
+	}
+	return true
+}
+
+func globMatch(pattern, value string) bool {
+	ok, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return ok
+}
+
+// ObjectFieldBinding ties a set of field paths to ignore in the diff to the resources
+// (by kind/name-glob/namespace-glob) it applies to.
+type ObjectFieldBinding struct {
+	ObjectGlobSelector
+	Fields []string `json:"fields,omitempty"`
+}
+
+// ObjectFieldBindingList is matched top-to-bottom; the fields of every binding whose
+// selector matches obj are combined.
+type ObjectFieldBindingList []ObjectFieldBinding
+
+// Match returns the ignore-fields of every binding that matches obj, in list order.
+func (l ObjectFieldBindingList) Match(obj unstructured.Unstructured) (bool, []string) {
+	matched := false
+	fields := []string{}
+	for _, b := range l {
+		if b.Matches(obj.GetKind(), obj.GetName(), obj.GetNamespace()) {
+			matched = true
+			fields = append(fields, b.Fields...)
+		}
+	}
+	return matched, fields
+}
+
+// ObjectReferencePattern matches resources to skip verification for entirely.
+type ObjectReferencePattern struct {
+	ObjectGlobSelector
+}
+
+// ObjectReferencePatternList is matched top-to-bottom.
+type ObjectReferencePatternList []ObjectReferencePattern
+
+// Match reports whether obj matches any pattern in the list.
+func (l ObjectReferencePatternList) Match(obj unstructured.Unstructured) bool {
+	for _, p := range l {
+		if p.Matches(obj.GetKind(), obj.GetName(), obj.GetNamespace()) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignerList is the set of signer identities VerifyResource accepts. An empty list
+// accepts any signer that produced a valid signature.
+type SignerList []string
+
+// Match reports whether signer is allowed by this list.
+func (l SignerList) Match(signer string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, s := range l {
+		if ok, err := path.Match(s, signer); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyResourceOption configures VerifyResource.
+type VerifyResourceOption struct {
+	ImageRef               string                     `json:"imageRef,omitempty"`
+	SignatureResourceRef   string                     `json:"signatureResourceRef,omitempty"`
+	AnnotationConfig       AnnotationConfig           `json:"annotationConfig,omitempty"`
+	SkipObjects            ObjectReferencePatternList `json:"skipObjects,omitempty"`
+	IgnoreFields           ObjectFieldBindingList     `json:"ignoreFields,omitempty"`
+	KeyPath                string                     `json:"keyPath,omitempty"`
+	Signers                SignerList                 `json:"signers,omitempty"`
+	DryRunNamespace        string                     `json:"dryRunNamespace,omitempty"`
+	CheckDryRunForApply    bool                       `json:"checkDryRunForApply,omitempty"`
+	MaxResourceManifestNum int                        `json:"maxResourceManifestNum,omitempty"`
+	Provenance             bool                       `json:"provenance,omitempty"`
+	ProvenanceResourceRef  string                     `json:"provenanceResourceRef,omitempty"`
+
+	// UseServerSideApply switches CASE3 of matchResourceWithManifest from the client-side
+	// "apply then dryrun create" simulation to a real Server-Side Apply dry-run. Falls
+	// back to the client-side simulation automatically if the API server rejects it (see
+	// kubeutil.IsServerSideApplyUnsupported).
+	UseServerSideApply bool `json:"useServerSideApply,omitempty"`
+
+	// Normalizers run (in addition to the built-in defaultNormalizers) before the final
+	// Diff, so fields the API server or a controller always mutates don't show up as
+	// false-positive mismatches.
+	Normalizers []ResourceNormalizer `json:"-"`
+
+	// Decryptors run (in addition to the built-in SOPS decryptor) over each manifest
+	// candidate before it is diffed, so an encrypted-at-rest manifest candidate is
+	// compared against its plaintext rather than its ciphertext.
+	Decryptors []ManifestDecryptor `json:"-"`
+
+	// RestConfig directs dry-run/apply simulation and resource fetching at a specific
+	// cluster instead of the ambient in-process config. VerifyResourceMulti sets this
+	// per cluster; single-cluster callers normally leave it nil.
+	RestConfig *rest.Config `json:"-"`
+
+	// manifestCache, when set, memoizes manifest-fetch results with a TTL so the same
+	// signed image isn't re-pulled once per cluster/resource within one VerifyResourceMulti
+	// call. It is unexported and scoped to that call: a plain VerifyResource call (not
+	// going through VerifyResourceMulti) never caches.
+	manifestCache *manifestCache
+}
+
+// SetAnnotationIgnoreFields adds this package's own signing annotations (image-ref,
+// compare-options, ...) to IgnoreFields, so a resource's own signing metadata never
+// shows up as a diff against a manifest that predates it.
+func (vo *VerifyResourceOption) SetAnnotationIgnoreFields() {
+	annotationPaths := []string{
+		fmt.Sprintf("metadata.annotations.%s", vo.AnnotationConfig.ImageRefAnnotationKey()),
+		fmt.Sprintf("metadata.annotations.%s", vo.AnnotationConfig.CompareOptionsAnnotationKey()),
+	}
+	for _, annotationPath := range annotationPaths {
+		alreadyIgnored := false
+		for _, b := range vo.IgnoreFields {
+			if b.Kind == "" && b.Name == "" && b.Namespace == "" && len(b.Fields) == 1 && b.Fields[0] == annotationPath {
+				alreadyIgnored = true
+				break
+			}
+		}
+		if !alreadyIgnored {
+			vo.IgnoreFields = append(vo.IgnoreFields, ObjectFieldBinding{Fields: []string{annotationPath}})
+		}
+	}
+}
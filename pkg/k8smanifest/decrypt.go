@@ -0,0 +1,118 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/ghodss/yaml"
+)
+
+// ManifestDecryptor decrypts a manifest candidate pulled from the signed image before it
+// is diffed against the live object. The signature itself is still verified over the
+// original (possibly encrypted) bytes pulled from the image, so the signer's intent is
+// preserved; decryption only changes what gets diffed.
+type ManifestDecryptor interface {
+	// Decrypt returns the plaintext manifest and the top-level fields that were
+	// decrypted, when manifestBytes is the kind of ciphertext this decryptor handles.
+	// applicable is false (manifestBytes returned unchanged) when this decryptor
+	// doesn't recognize the input, so callers can try the next one in the chain.
+	Decrypt(manifestBytes []byte) (plaintext []byte, decryptedFields []string, applicable bool, err error)
+}
+
+// decryptManifestCandidate runs manifestBytes through decryptors in order and returns the
+// plaintext produced by the first one that recognizes it, along with the fields it
+// decrypted. manifestBytes is returned unchanged if no decryptor applies.
+func decryptManifestCandidate(manifestBytes []byte, decryptors []ManifestDecryptor) ([]byte, []string, error) {
+	for _, d := range decryptors {
+		plaintext, fields, applicable, err := d.Decrypt(manifestBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if applicable {
+			return plaintext, fields, nil
+		}
+	}
+	return manifestBytes, nil, nil
+}
+
+// SOPSDecryptor decrypts SOPS-encrypted manifests (age, PGP, or KMS, depending on how the
+// `sops` binary on PATH is configured) by shelling out to `sops --decrypt`. It reads the
+// age identity from the SOPS_AGE_KEY_FILE environment variable and otherwise defers to
+// whatever `sops` itself resolves from the environment (KMS role, PGP agent, etc).
+type SOPSDecryptor struct {
+	// SOPSBinary overrides the `sops` binary to invoke. Defaults to "sops" on PATH.
+	SOPSBinary string
+}
+
+// NewSOPSDecryptor returns a SOPSDecryptor that uses the `sops` binary on PATH.
+func NewSOPSDecryptor() *SOPSDecryptor {
+	return &SOPSDecryptor{SOPSBinary: "sops"}
+}
+
+func (d *SOPSDecryptor) Decrypt(manifestBytes []byte) ([]byte, []string, bool, error) {
+	if !isSOPSEncrypted(manifestBytes) {
+		return manifestBytes, nil, false, nil
+	}
+
+	binary := d.SOPSBinary
+	if binary == "" {
+		binary = "sops"
+	}
+
+	cmd := exec.Command(binary, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", "/dev/stdin")
+	cmd.Env = os.Environ()
+	cmd.Stdin = bytes.NewReader(manifestBytes)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, true, fmt.Errorf("sops --decrypt failed: %s: %s", err.Error(), stderr.String())
+	}
+
+	return stdout.Bytes(), sopsDataFields(manifestBytes), true, nil
+}
+
+// isSOPSEncrypted reports whether manifestBytes carries SOPS' "sops" metadata stanza.
+func isSOPSEncrypted(manifestBytes []byte) bool {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(manifestBytes, &m); err != nil {
+		return false
+	}
+	_, ok := m["sops"]
+	return ok
+}
+
+// sopsDataFields lists the top-level fields (besides the "sops" metadata stanza itself)
+// of a SOPS-encrypted manifest; these are the fields reported as decrypted for the match.
+func sopsDataFields(manifestBytes []byte) []string {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(manifestBytes, &m); err != nil {
+		return nil
+	}
+	fields := []string{}
+	for k := range m {
+		if k == "sops" {
+			continue
+		}
+		fields = append(fields, k)
+	}
+	return fields
+}
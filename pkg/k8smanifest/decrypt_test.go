@@ -0,0 +1,80 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIsSOPSEncrypted(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  []byte
+		want bool
+	}{
+		{
+			name: "sops stanza present",
+			raw:  []byte("apiVersion: v1\nkind: ConfigMap\ndata:\n  key: ENC[AES256_GCM,data:xxx]\nsops:\n  age: []\n"),
+			want: true,
+		},
+		{
+			name: "no sops stanza",
+			raw:  []byte("apiVersion: v1\nkind: ConfigMap\ndata:\n  key: plain\n"),
+			want: false,
+		},
+		{
+			name: "invalid yaml",
+			raw:  []byte("not: [valid"),
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isSOPSEncrypted(c.raw); got != c.want {
+				t.Errorf("isSOPSEncrypted() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSopsDataFields(t *testing.T) {
+	raw := []byte("apiVersion: v1\nkind: ConfigMap\ndata:\n  key: ENC[AES256_GCM,data:xxx]\nsops:\n  age: []\n")
+
+	got := sopsDataFields(raw)
+	sort.Strings(got)
+	want := []string{"apiVersion", "data", "kind"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sopsDataFields() = %v, want %v", got, want)
+	}
+}
+
+func TestDecryptManifestCandidateNoApplicableDecryptor(t *testing.T) {
+	manifestBytes := []byte("apiVersion: v1\nkind: ConfigMap\ndata:\n  key: plain\n")
+
+	got, fields, err := decryptManifestCandidate(manifestBytes, []ManifestDecryptor{NewSOPSDecryptor()})
+	if err != nil {
+		t.Fatalf("decryptManifestCandidate() error = %v", err)
+	}
+	if string(got) != string(manifestBytes) {
+		t.Errorf("expected manifestBytes unchanged when no decryptor applies, got %q", got)
+	}
+	if fields != nil {
+		t.Errorf("expected no decrypted fields, got %v", fields)
+	}
+}
@@ -0,0 +1,97 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"fmt"
+
+	mapnode "github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// VerifyFailureReasonCode is a machine-consumable code identifying why VerifyResource
+// did not verify a resource, so admission-webhook callers and policy engines can key off
+// it without regex-scraping VerifyResourceResult.Diff.String().
+type VerifyFailureReasonCode string
+
+const (
+	ReasonCodeImageRefMissing       VerifyFailureReasonCode = "ImageRefMissing"
+	ReasonCodeManifestNotFound      VerifyFailureReasonCode = "ManifestNotFound"
+	ReasonCodeManifestMismatch      VerifyFailureReasonCode = "ManifestMismatch"
+	ReasonCodeFieldMutated          VerifyFailureReasonCode = "FieldMutated"
+	ReasonCodeFieldAddedByAdmission VerifyFailureReasonCode = "FieldAddedByAdmission"
+	ReasonCodeNamespaceMismatch     VerifyFailureReasonCode = "NamespaceMismatch"
+	ReasonCodeSignatureInvalid      VerifyFailureReasonCode = "SignatureInvalid"
+	ReasonCodeSignerNotAllowed      VerifyFailureReasonCode = "SignerNotAllowed"
+)
+
+// VerifyFailureReason is one actionable reason a verification failed or did not fully
+// match, e.g. {Code: FieldMutated, Path: "spec.replicas", Expected: "3", Actual: "5"}.
+type VerifyFailureReason struct {
+	Code     VerifyFailureReasonCode `json:"code"`
+	Message  string                  `json:"message"`
+	Path     string                  `json:"path,omitempty"`
+	Expected string                  `json:"expected,omitempty"`
+	Actual   string                  `json:"actual,omitempty"`
+}
+
+func newReason(code VerifyFailureReasonCode, message string) VerifyFailureReason {
+	return VerifyFailureReason{Code: code, Message: message}
+}
+
+// diffFailureReasons converts a mapnode.DiffResult into one VerifyFailureReason per
+// differing field, so a policy engine can act on "spec.replicas" directly instead of
+// regex-scraping diff.String(). Every match case in this package (directMatch,
+// dryrunCreateMatch, dryrunApplyMatch, dryrunServerSideApplyMatch) computes diff as
+// obj.Diff(candidate), so DiffElement.Before is always the live value and
+// DiffElement.After is always the value the signed manifest implies; that's why Expected
+// is sourced from After and Actual from Before below. A field present in the signed
+// manifest but changed live is classified FieldMutated; a field missing from the signed
+// manifest but present live (e.g. something admission/a controller added) is
+// FieldAddedByAdmission; a mutated metadata.namespace is reported as NamespaceMismatch
+// since that's rarely accidental.
+func diffFailureReasons(diff *mapnode.DiffResult) []VerifyFailureReason {
+	if diff == nil {
+		return nil
+	}
+	if diff.Size() == 0 {
+		return []VerifyFailureReason{newReason(ReasonCodeManifestMismatch, diff.String())}
+	}
+
+	reasons := make([]VerifyFailureReason, 0, len(diff.Items))
+	for _, item := range diff.Items {
+		path := item.Key
+		expected := item.After
+		actual := item.Before
+
+		code := ReasonCodeFieldMutated
+		switch {
+		case path == "metadata.namespace":
+			code = ReasonCodeNamespaceMismatch
+		case expected == "" && actual != "":
+			code = ReasonCodeFieldAddedByAdmission
+		}
+
+		reasons = append(reasons, VerifyFailureReason{
+			Code:     code,
+			Message:  fmt.Sprintf("field %s differs: signed=%s live=%s", path, expected, actual),
+			Path:     path,
+			Expected: expected,
+			Actual:   actual,
+		})
+	}
+	return reasons
+}
@@ -0,0 +1,114 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"testing"
+
+	mapnode "github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+func TestDiffFailureReasonsNil(t *testing.T) {
+	if got := diffFailureReasons(nil); got != nil {
+		t.Errorf("diffFailureReasons(nil) = %v, want nil", got)
+	}
+}
+
+func TestDiffFailureReasonsFromRealDiff(t *testing.T) {
+	live := []byte(`{"metadata":{"name":"app","namespace":"prod"},"spec":{"replicas":5}}`)
+	signed := []byte(`{"metadata":{"name":"app","namespace":"staging"},"spec":{"replicas":3}}`)
+
+	liveNode, err := mapnode.NewFromBytes(live)
+	if err != nil {
+		t.Fatalf("NewFromBytes(live) error = %v", err)
+	}
+	signedNode, err := mapnode.NewFromBytes(signed)
+	if err != nil {
+		t.Fatalf("NewFromBytes(signed) error = %v", err)
+	}
+
+	diff := liveNode.Diff(signedNode)
+	if diff == nil {
+		t.Fatal("Diff() = nil, want a non-nil result for these inputs")
+	}
+
+	reasons := diffFailureReasons(diff)
+
+	byPath := map[string]VerifyFailureReason{}
+	for _, r := range reasons {
+		byPath[r.Path] = r
+	}
+
+	replicas, ok := byPath["spec.replicas"]
+	if !ok {
+		t.Fatalf("missing reason for spec.replicas, got %+v", reasons)
+	}
+	if replicas.Code != ReasonCodeFieldMutated {
+		t.Errorf("spec.replicas Code = %s, want %s", replicas.Code, ReasonCodeFieldMutated)
+	}
+	if replicas.Expected != "3" || replicas.Actual != "5" {
+		t.Errorf("spec.replicas Expected/Actual = %q/%q, want \"3\"/\"5\"", replicas.Expected, replicas.Actual)
+	}
+	if replicas.Message != "field spec.replicas differs: signed=3 live=5" {
+		t.Errorf("spec.replicas Message = %q", replicas.Message)
+	}
+
+	namespace, ok := byPath["metadata.namespace"]
+	if !ok {
+		t.Fatalf("missing reason for metadata.namespace, got %+v", reasons)
+	}
+	if namespace.Code != ReasonCodeNamespaceMismatch {
+		t.Errorf("metadata.namespace Code = %s, want %s", namespace.Code, ReasonCodeNamespaceMismatch)
+	}
+	if namespace.Expected != "staging" || namespace.Actual != "prod" {
+		t.Errorf("metadata.namespace Expected/Actual = %q/%q, want \"staging\"/\"prod\"", namespace.Expected, namespace.Actual)
+	}
+}
+
+func TestDiffFailureReasonsFieldAddedByAdmission(t *testing.T) {
+	live := []byte(`{"metadata":{"name":"app","annotations":{"injected-by":"admission-webhook"}}}`)
+	signed := []byte(`{"metadata":{"name":"app"}}`)
+
+	liveNode, err := mapnode.NewFromBytes(live)
+	if err != nil {
+		t.Fatalf("NewFromBytes(live) error = %v", err)
+	}
+	signedNode, err := mapnode.NewFromBytes(signed)
+	if err != nil {
+		t.Fatalf("NewFromBytes(signed) error = %v", err)
+	}
+
+	diff := liveNode.Diff(signedNode)
+	reasons := diffFailureReasons(diff)
+
+	var found bool
+	for _, r := range reasons {
+		if r.Path != "metadata.annotations.injected-by" {
+			continue
+		}
+		found = true
+		if r.Code != ReasonCodeFieldAddedByAdmission {
+			t.Errorf("Code = %s, want %s", r.Code, ReasonCodeFieldAddedByAdmission)
+		}
+		if r.Expected != "" || r.Actual != "admission-webhook" {
+			t.Errorf("Expected/Actual = %q/%q, want \"\"/\"admission-webhook\"", r.Expected, r.Actual)
+		}
+	}
+	if !found {
+		t.Fatalf("missing reason for metadata.annotations.injected-by, got %+v", reasons)
+	}
+}
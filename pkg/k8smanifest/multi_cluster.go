@@ -0,0 +1,224 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+
+	kubeutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+)
+
+// ClusterRef identifies one cluster to fan a multi-cluster verification out to, either
+// via a kubeconfig context or the in-cluster config of the process itself.
+type ClusterRef struct {
+	// Name is an arbitrary label used to identify this cluster in results, e.g. "prod-eu".
+	Name string
+	// KubeconfigPath and Context select a context out of a kubeconfig file. Leave both
+	// empty together with InCluster=true to use the ambient in-cluster config instead.
+	KubeconfigPath string
+	Context        string
+	InCluster      bool
+}
+
+func (c ClusterRef) restConfig() (*rest.Config, error) {
+	if c.InCluster {
+		return kubeutil.InClusterRestConfig()
+	}
+	return kubeutil.RestConfigFromKubeconfig(c.KubeconfigPath, c.Context)
+}
+
+// ResourceSelector picks the resources to fetch from each cluster for verification.
+type ResourceSelector struct {
+	APIVersion    string
+	Kind          string
+	Namespace     string
+	Name          string
+	LabelSelector string
+}
+
+// ClusterVerifyResult is one cluster's outcome for a single resource selected by a
+// ResourceSelector, returned by VerifyResourceMulti.
+type ClusterVerifyResult struct {
+	Cluster   string                    `json:"cluster"`
+	Namespace string                    `json:"namespace"`
+	Object    unstructured.Unstructured `json:"object"`
+	Result    *VerifyResourceResult     `json:"result"`
+	// ErrMessage is Err.Error(), kept as a plain string because github.com/pkg/errors
+	// values carry unexported fields and no MarshalJSON, so an `error`-typed field here
+	// would serialize as an empty "{}" and silently drop the diagnostic.
+	ErrMessage string `json:"errMessage,omitempty"`
+}
+
+// VerifyResourceMulti fetches the resources matching selector from every cluster in refs
+// in parallel and runs VerifyResource against each of them, so tooling can render a
+// cross-cluster verification matrix (e.g. "is this signed Deployment identical across
+// prod-eu, prod-us, staging?").
+func VerifyResourceMulti(refs []ClusterRef, selector ResourceSelector, vo *VerifyResourceOption) ([]ClusterVerifyResult, error) {
+	// Shared across every cluster/resource in this one fan-out call, with a TTL, so a
+	// single signed image is pulled at most once per refresh window instead of once per
+	// cluster. It is never shared across separate VerifyResourceMulti calls.
+	cache := newManifestCache(0)
+
+	var wg sync.WaitGroup
+	resultsPerCluster := make([][]ClusterVerifyResult, len(refs))
+
+	for i, ref := range refs {
+		wg.Add(1)
+		go func(i int, ref ClusterRef) {
+			defer wg.Done()
+			resultsPerCluster[i] = verifyResourceOnCluster(ref, selector, vo, cache)
+		}(i, ref)
+	}
+	wg.Wait()
+
+	results := []ClusterVerifyResult{}
+	for _, perCluster := range resultsPerCluster {
+		results = append(results, perCluster...)
+	}
+	return results, nil
+}
+
+func verifyResourceOnCluster(ref ClusterRef, selector ResourceSelector, vo *VerifyResourceOption, cache *manifestCache) []ClusterVerifyResult {
+	restConfig, err := ref.restConfig()
+	if err != nil {
+		return []ClusterVerifyResult{{Cluster: ref.Name, ErrMessage: errors.Wrap(err, "failed to build REST config for cluster").Error()}}
+	}
+
+	objs, err := kubeutil.GetResources(restConfig, selector.APIVersion, selector.Kind, selector.Namespace, selector.Name, selector.LabelSelector)
+	if err != nil {
+		return []ClusterVerifyResult{{Cluster: ref.Name, ErrMessage: errors.Wrap(err, "failed to list resources on cluster").Error()}}
+	}
+
+	clusterVO := vo
+	if vo != nil {
+		voCopy := *vo
+		voCopy.RestConfig = restConfig
+		voCopy.manifestCache = cache
+		clusterVO = &voCopy
+	}
+
+	results := make([]ClusterVerifyResult, 0, len(objs))
+	for _, obj := range objs {
+		r, verr := VerifyResource(obj, clusterVO)
+		errMessage := ""
+		if verr != nil {
+			errMessage = verr.Error()
+			log.Debugf("VerifyResource failed on cluster %s for %s/%s: %s", ref.Name, obj.GetNamespace(), obj.GetName(), errMessage)
+		}
+		results = append(results, ClusterVerifyResult{
+			Cluster:    ref.Name,
+			Namespace:  obj.GetNamespace(),
+			Object:     obj,
+			Result:     r,
+			ErrMessage: errMessage,
+		})
+	}
+	return results
+}
+
+// dryRunCreateForCluster routes a dry-run create through restConfig when set (multi-cluster
+// verification), falling back to kubeutil's ambient-config client otherwise.
+func dryRunCreateForCluster(manifestBytes []byte, namespace string, restConfig *rest.Config) ([]byte, error) {
+	if restConfig == nil {
+		return kubeutil.DryRunCreate(manifestBytes, namespace)
+	}
+	return kubeutil.DryRunCreateWithConfig(restConfig, manifestBytes, namespace)
+}
+
+// getApplyPatchBytesForCluster routes the client-side apply simulation through restConfig
+// when set (multi-cluster verification), falling back to the ambient-config client otherwise.
+func getApplyPatchBytesForCluster(manifestBytes []byte, namespace string, restConfig *rest.Config) ([]byte, []byte, error) {
+	if restConfig == nil {
+		return kubeutil.GetApplyPatchBytes(manifestBytes, namespace)
+	}
+	return kubeutil.GetApplyPatchBytesWithConfig(restConfig, manifestBytes, namespace)
+}
+
+// defaultManifestCacheTTL bounds how long a manifest fetch is reused for within one
+// manifestCache before it is pulled again, so a signer re-signing mid-run is eventually
+// picked up without needing an explicit cache-bust.
+const defaultManifestCacheTTL = 5 * time.Minute
+
+// manifestCache memoizes NewManifestFetcher(...).Fetch() results with a TTL, scoped to a
+// single VerifyResourceMulti call: it is created fresh by that call and handed to every
+// cluster's VerifyResourceOption, so a signed image shared across clusters/resources is
+// pulled at most once per TTL window instead of once per cluster. It is never attached to
+// a VerifyResourceOption outside of VerifyResourceMulti, so the pre-existing
+// single-cluster VerifyResource entry point is unaffected and two unrelated callers can
+// never end up sharing a result through it.
+type manifestCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*manifestCacheEntry
+}
+
+// manifestCacheEntry's once ensures exactly one caller actually runs the fetch for this
+// key, even when several clusters ask for the same key at roughly the same time: every
+// concurrent caller is handed the same *manifestCacheEntry and blocks on the same Once
+// instead of racing to fetch independently.
+type manifestCacheEntry struct {
+	once sync.Once
+
+	manifests [][]byte
+	sigRef    string
+	err       error
+	expiresAt time.Time
+}
+
+func newManifestCache(ttl time.Duration) *manifestCache {
+	if ttl <= 0 {
+		ttl = defaultManifestCacheTTL
+	}
+	return &manifestCache{ttl: ttl, entries: map[string]*manifestCacheEntry{}}
+}
+
+func manifestFetchCacheKey(imageRef, sigResourceRef string, ignoreFields []string) string {
+	return strings.Join([]string{imageRef, sigResourceRef, strings.Join(ignoreFields, ",")}, "|")
+}
+
+func (c *manifestCache) fetch(imageRef, sigResourceRef string, annotationConfig AnnotationConfig, ignoreFields []string, maxResourceManifestNum int, objBytes []byte) ([][]byte, string, error) {
+	key := manifestFetchCacheKey(imageRef, sigResourceRef, ignoreFields)
+	return c.fetchWithKey(key, func() ([][]byte, string, error) {
+		return NewManifestFetcher(imageRef, sigResourceRef, annotationConfig, ignoreFields, maxResourceManifestNum).Fetch(objBytes)
+	})
+}
+
+// fetchWithKey de-duplicates concurrent callers sharing key onto a single call to fetch,
+// the way (c *manifestCache).fetch does for real manifest fetches; split out so the
+// de-duplication itself can be exercised without NewManifestFetcher.
+func (c *manifestCache) fetchWithKey(key string, fetch func() ([][]byte, string, error)) ([][]byte, string, error) {
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if !found || time.Now().After(entry.expiresAt) {
+		entry = &manifestCacheEntry{expiresAt: time.Now().Add(c.ttl)}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.manifests, entry.sigRef, entry.err = fetch()
+	})
+	return entry.manifests, entry.sigRef, entry.err
+}
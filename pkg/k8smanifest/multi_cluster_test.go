@@ -0,0 +1,107 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestManifestFetchCacheKey(t *testing.T) {
+	k1 := manifestFetchCacheKey("img:v1", "", []string{"status"})
+	k2 := manifestFetchCacheKey("img:v1", "", []string{"status"})
+	if k1 != k2 {
+		t.Errorf("same inputs should produce the same key: %q != %q", k1, k2)
+	}
+
+	k3 := manifestFetchCacheKey("img:v2", "", []string{"status"})
+	if k1 == k3 {
+		t.Errorf("different imageRef should produce a different key, both were %q", k1)
+	}
+
+	k4 := manifestFetchCacheKey("img:v1", "sig-ref", []string{"status"})
+	if k1 == k4 {
+		t.Errorf("different sigResourceRef should produce a different key, both were %q", k1)
+	}
+
+	k5 := manifestFetchCacheKey("img:v1", "", []string{"status", "spec.replicas"})
+	if k1 == k5 {
+		t.Errorf("different ignoreFields should produce a different key, both were %q", k1)
+	}
+}
+
+// TestManifestCacheFetchWithKeyDedupesConcurrentCallers simulates VerifyResourceMulti
+// fanning the same image ref out to many clusters at once: every concurrent caller for
+// the same key must converge on one fetch instead of each missing the cache and pulling
+// independently.
+func TestManifestCacheFetchWithKeyDedupesConcurrentCallers(t *testing.T) {
+	c := newManifestCache(0)
+
+	var calls int32
+	const callers = 20
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			manifests, sigRef, err := c.fetchWithKey("same-key", func() ([][]byte, string, error) {
+				atomic.AddInt32(&calls, 1)
+				return [][]byte{[]byte("manifest")}, "sig-ref", nil
+			})
+			if err != nil {
+				t.Errorf("fetchWithKey() error = %v", err)
+			}
+			if sigRef != "sig-ref" || len(manifests) != 1 {
+				t.Errorf("fetchWithKey() = %v, %q, want the fetched result", manifests, sigRef)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch ran %d times for the same key, want exactly 1", got)
+	}
+}
+
+// TestManifestCacheFetchWithKeyRefetchesAfterExpiry confirms the TTL is still honored:
+// once an entry expires, the next caller re-runs fetch rather than reusing it forever.
+func TestManifestCacheFetchWithKeyRefetchesAfterExpiry(t *testing.T) {
+	c := newManifestCache(0)
+
+	var calls int32
+	fetch := func() ([][]byte, string, error) {
+		atomic.AddInt32(&calls, 1)
+		return [][]byte{[]byte("manifest")}, "sig-ref", nil
+	}
+
+	if _, _, err := c.fetchWithKey("key", fetch); err != nil {
+		t.Fatalf("fetchWithKey() error = %v", err)
+	}
+
+	c.mu.Lock()
+	c.entries["key"].expiresAt = c.entries["key"].expiresAt.Add(-2 * defaultManifestCacheTTL)
+	c.mu.Unlock()
+
+	if _, _, err := c.fetchWithKey("key", fetch); err != nil {
+		t.Fatalf("fetchWithKey() error = %v", err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("fetch ran %d times across one expiry, want exactly 2", got)
+	}
+}
@@ -0,0 +1,286 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	mapnode "github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// CompareOptionsAnnotationBaseName is the annotation base name that carries a
+// comma-separated list of per-resource compare options, e.g.
+//
+//	k8s-manifest-sigstore/compare-options: "IgnoreExtraneous,ServerSideDiff=true"
+//
+// This mirrors Argo CD's `compare-options` annotation.
+const CompareOptionsAnnotationBaseName = "compare-options"
+
+// CompareOptions is the parsed form of the compare-options annotation.
+type CompareOptions struct {
+	// IgnoreExtraneous drops top-level fields that exist on the live object but are
+	// absent from the signed manifest candidate, instead of failing verification on them.
+	IgnoreExtraneous bool
+	// ServerSideDiff is accepted for Argo CD annotation compatibility. This package
+	// always resolves the live object from the cluster, so it has no additional effect.
+	ServerSideDiff bool
+}
+
+// parseCompareOptions parses the value of a compare-options annotation. Unknown or
+// malformed entries are ignored so that annotations shared with other tooling (e.g. Argo
+// CD options this package does not implement) don't cause an error.
+func parseCompareOptions(raw string) CompareOptions {
+	opts := CompareOptions{}
+	if raw == "" {
+		return opts
+	}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		key := part
+		val := "true"
+		if i := strings.Index(part, "="); i >= 0 {
+			key = part[:i]
+			val = part[i+1:]
+		}
+		switch key {
+		case "IgnoreExtraneous":
+			opts.IgnoreExtraneous = val == "true"
+		case "ServerSideDiff":
+			opts.ServerSideDiff = val == "true"
+		}
+	}
+	return opts
+}
+
+// extraneousIgnoreFields returns the top-level fields present on the live object but
+// absent from the manifest candidate. It backs CompareOptions.IgnoreExtraneous.
+func extraneousIgnoreFields(objBytes, manifestBytes []byte) []string {
+	var objMap map[string]interface{}
+	if err := json.Unmarshal(objBytes, &objMap); err != nil {
+		return nil
+	}
+	var mnfMap map[string]interface{}
+	if err := yaml.Unmarshal(manifestBytes, &mnfMap); err != nil {
+		return nil
+	}
+	fields := []string{}
+	for k := range objMap {
+		if _, ok := mnfMap[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	return fields
+}
+
+// ResourceNormalizer rewrites both the live object and the manifest candidate into an
+// equivalent shape before they are diffed, so that fields the API server (or a
+// well-known controller) always mutates don't show up as a false-positive mismatch.
+// This mirrors Argo CD's built-in "known type" normalizers. Selector reuses the same
+// (kind, name-glob, namespace-glob) matching as VerifyResourceOption.IgnoreFields, so a
+// caller who already knows how to scope an ignore-fields entry to a resource knows how to
+// scope a normalizer too.
+type ResourceNormalizer struct {
+	ObjectGlobSelector
+
+	// JSONPointers lists the dot-notation field paths this normalizer rewrites or
+	// masks. It is informational (surfaced for logging/debugging); Transform is what
+	// actually runs.
+	JSONPointers []string
+
+	// Transform rewrites the live object JSON bytes and the manifest YAML bytes before
+	// they are compared. Either value may be returned unchanged if nothing applies.
+	Transform func(objBytes, manifestBytes []byte) ([]byte, []byte, error)
+}
+
+// applyNormalizers runs every normalizer whose selector matches (kind, name, namespace)
+// against the live object and manifest candidate, in order. Each match function calls it
+// first, on the bytes it was given, before any Mask() call. In the dry-run match cases the
+// normalized manifest is what is sent to the API server; the object the API server
+// returns is diffed without being normalized.
+func applyNormalizers(kind, name, namespace string, objBytes, manifestBytes []byte, normalizers []ResourceNormalizer) ([]byte, []byte, error) {
+	for _, n := range normalizers {
+		if n.Transform == nil || !n.Matches(kind, name, namespace) {
+			continue
+		}
+		var err error
+		objBytes, manifestBytes, err = n.Transform(objBytes, manifestBytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("normalizer for kind %q failed: %s", kind, err.Error())
+		}
+	}
+	return objBytes, manifestBytes, nil
+}
+
+// defaultNormalizers returns the built-in set of normalizers for fields that Kubernetes
+// itself (or a well-known controller) populates or mutates on every resource, regardless
+// of what a signed manifest declared.
+func defaultNormalizers() []ResourceNormalizer {
+	return []ResourceNormalizer{
+		{
+			ObjectGlobSelector: ObjectGlobSelector{Kind: "Secret"},
+			JSONPointers:       []string{"stringData", "data"},
+			Transform:          normalizeSecretStringData,
+		},
+		{
+			ObjectGlobSelector: ObjectGlobSelector{Kind: "Deployment"},
+			JSONPointers:       []string{"spec.template.metadata.creationTimestamp"},
+			Transform:          maskFields([]string{"spec.template.metadata.creationTimestamp"}),
+		},
+		{
+			ObjectGlobSelector: ObjectGlobSelector{Kind: "HorizontalPodAutoscaler"},
+			JSONPointers:       []string{"status"},
+			Transform:          maskFields([]string{"status"}),
+		},
+		{
+			ObjectGlobSelector: ObjectGlobSelector{Kind: "PersistentVolumeClaim"},
+			JSONPointers:       []string{"spec.volumeName"},
+			Transform:          maskFields([]string{"spec.volumeName"}),
+		},
+		{
+			// Only the controller-populated auto-mount token entry in secrets[] is
+			// noise; a deliberately pinned secret reference is real drift and must
+			// still surface in the diff.
+			ObjectGlobSelector: ObjectGlobSelector{Kind: "ServiceAccount"},
+			JSONPointers:       []string{"secrets"},
+			Transform:          normalizeServiceAccountAutoMountedSecrets,
+		},
+		{
+			// metadata.managedFields is populated by the API server for every kind.
+			JSONPointers: []string{"metadata.managedFields"},
+			Transform:    maskFields([]string{"metadata.managedFields"}),
+		},
+	}
+}
+
+// maskFields returns a Transform that removes the same set of fields from both the live
+// object and the manifest candidate.
+func maskFields(fields []string) func(objBytes, manifestBytes []byte) ([]byte, []byte, error) {
+	return func(objBytes, manifestBytes []byte) ([]byte, []byte, error) {
+		objNode, err := mapnode.NewFromBytes(objBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		mnfNode, err := mapnode.NewFromYamlBytes(manifestBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		maskedObjBytes, err := yaml.YAMLToJSON([]byte(objNode.Mask(fields).ToYaml()))
+		if err != nil {
+			return nil, nil, err
+		}
+		maskedManifestBytes := []byte(mnfNode.Mask(fields).ToYaml())
+		return maskedObjBytes, maskedManifestBytes, nil
+	}
+}
+
+// normalizeSecretStringData rewrites a Secret's stringData into data (base64-encoded),
+// the same way the API server does when it persists the resource, so a manifest authored
+// with stringData compares equal to the live object that only ever has data.
+func normalizeSecretStringData(objBytes, manifestBytes []byte) ([]byte, []byte, error) {
+	newObjBytes, err := stringDataToData(objBytes, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	newManifestBytes, err := stringDataToData(manifestBytes, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newObjBytes, newManifestBytes, nil
+}
+
+// normalizeServiceAccountAutoMountedSecrets drops only the secrets[] entries the API
+// server auto-appends for a ServiceAccount's legacy auto-mount token (named
+// "<serviceaccount-name>-token-<random suffix>"), leaving any other, deliberately pinned
+// secret reference in place so drift on those is still caught by the diff.
+func normalizeServiceAccountAutoMountedSecrets(objBytes, manifestBytes []byte) ([]byte, []byte, error) {
+	newObjBytes, err := dropAutoMountedSecrets(objBytes, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	newManifestBytes, err := dropAutoMountedSecrets(manifestBytes, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newObjBytes, newManifestBytes, nil
+}
+
+func dropAutoMountedSecrets(raw []byte, outputJSON bool) ([]byte, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	secrets, ok := m["secrets"].([]interface{})
+	if !ok {
+		return raw, nil
+	}
+	metadata, _ := m["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	prefix := name + "-token-"
+
+	filtered := make([]interface{}, 0, len(secrets))
+	for _, s := range secrets {
+		entry, ok := s.(map[string]interface{})
+		if !ok {
+			filtered = append(filtered, s)
+			continue
+		}
+		secretName, _ := entry["name"].(string)
+		if name != "" && strings.HasPrefix(secretName, prefix) {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+	m["secrets"] = filtered
+
+	if outputJSON {
+		return json.Marshal(m)
+	}
+	return yaml.Marshal(m)
+}
+
+func stringDataToData(raw []byte, outputJSON bool) ([]byte, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	stringData, ok := m["stringData"].(map[string]interface{})
+	if !ok {
+		return raw, nil
+	}
+	data, _ := m["data"].(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	for k, v := range stringData {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		data[k] = base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	m["data"] = data
+	delete(m, "stringData")
+	if outputJSON {
+		return json.Marshal(m)
+	}
+	return yaml.Marshal(m)
+}
@@ -0,0 +1,130 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestParseCompareOptions(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want CompareOptions
+	}{
+		{raw: "", want: CompareOptions{}},
+		{raw: "IgnoreExtraneous", want: CompareOptions{IgnoreExtraneous: true}},
+		{raw: "IgnoreExtraneous,ServerSideDiff=true", want: CompareOptions{IgnoreExtraneous: true, ServerSideDiff: true}},
+		{raw: "ServerSideDiff=false", want: CompareOptions{ServerSideDiff: false}},
+		{raw: "SomeUnknownOption=true", want: CompareOptions{}},
+	}
+	for _, c := range cases {
+		got := parseCompareOptions(c.raw)
+		if got != c.want {
+			t.Errorf("parseCompareOptions(%q) = %+v, want %+v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestExtraneousIgnoreFields(t *testing.T) {
+	objBytes := []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"x"},"data":{"a":"1"},"status":{"phase":"Ready"}}`)
+	manifestBytes := []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: x\ndata:\n  a: \"1\"\n")
+
+	got := extraneousIgnoreFields(objBytes, manifestBytes)
+	sort.Strings(got)
+	want := []string{"status"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extraneousIgnoreFields() = %v, want %v", got, want)
+	}
+}
+
+func TestStringDataToData(t *testing.T) {
+	raw := []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: x\nstringData:\n  key: plain\n")
+
+	got, err := stringDataToData(raw, false)
+	if err != nil {
+		t.Fatalf("stringDataToData() error = %v", err)
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(got, &m); err != nil {
+		t.Fatalf("failed to parse result: %v", err)
+	}
+	if _, found := m["stringData"]; found {
+		t.Errorf("stringData should have been removed, got %v", m)
+	}
+	data, ok := m["data"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("data field missing or wrong type: %v", m)
+	}
+	if data["key"] != "cGxhaW4=" {
+		t.Errorf("data[\"key\"] = %v, want base64-encoded \"plain\"", data["key"])
+	}
+}
+
+func TestResourceNormalizerMatches(t *testing.T) {
+	n := ResourceNormalizer{ObjectGlobSelector: ObjectGlobSelector{Kind: "Secret", Name: "app-*", Namespace: "prod-*"}}
+
+	cases := []struct {
+		kind, name, namespace string
+		want                  bool
+	}{
+		{"Secret", "app-config", "prod-eu", true},
+		{"Secret", "other-config", "prod-eu", false},
+		{"Secret", "app-config", "staging", false},
+		{"ConfigMap", "app-config", "prod-eu", false},
+	}
+	for _, c := range cases {
+		if got := n.Matches(c.kind, c.name, c.namespace); got != c.want {
+			t.Errorf("Matches(%q, %q, %q) = %v, want %v", c.kind, c.name, c.namespace, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeServiceAccountAutoMountedSecrets(t *testing.T) {
+	objBytes := []byte(`{"apiVersion":"v1","kind":"ServiceAccount","metadata":{"name":"default"},"secrets":[{"name":"default-token-abcde"},{"name":"pinned-secret"}]}`)
+	manifestBytes := []byte("apiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: default\nsecrets:\n- name: pinned-secret\n")
+
+	gotObjBytes, gotManifestBytes, err := normalizeServiceAccountAutoMountedSecrets(objBytes, manifestBytes)
+	if err != nil {
+		t.Fatalf("normalizeServiceAccountAutoMountedSecrets() error = %v", err)
+	}
+
+	var objM, manifestM map[string]interface{}
+	if err := yaml.Unmarshal(gotObjBytes, &objM); err != nil {
+		t.Fatalf("failed to parse normalized object: %v", err)
+	}
+	if err := yaml.Unmarshal(gotManifestBytes, &manifestM); err != nil {
+		t.Fatalf("failed to parse normalized manifest: %v", err)
+	}
+
+	objSecrets, _ := objM["secrets"].([]interface{})
+	if len(objSecrets) != 1 {
+		t.Fatalf("expected the auto-mounted token secret to be dropped, got %v", objSecrets)
+	}
+	entry, _ := objSecrets[0].(map[string]interface{})
+	if entry["name"] != "pinned-secret" {
+		t.Errorf("expected the pinned secret to survive, got %v", objSecrets)
+	}
+
+	manifestSecrets, _ := manifestM["secrets"].([]interface{})
+	if len(manifestSecrets) != 1 {
+		t.Errorf("expected manifest secrets to be unaffected, got %v", manifestSecrets)
+	}
+}
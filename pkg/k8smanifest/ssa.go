@@ -0,0 +1,101 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package k8smanifest
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+
+	kubeutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	mapnode "github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+// ServerSideApplyFieldManager is the fieldManager this package identifies itself as when
+// it issues a Server-Side Apply dry-run to simulate what an actual apply would produce.
+const ServerSideApplyFieldManager = "k8s-manifest-sigstore-verify"
+
+// dryrunServerSideApplyMatch replaces the client-side "apply then dryrun create" dance in
+// dryrunApplyMatch with a real Server-Side Apply dry-run (PATCH, application/apply-patch+yaml,
+// fieldManager=ServerSideApplyFieldManager, force=false, dryRun=All). The API server
+// returns the fully merged object honoring field ownership, defaulting, and admission,
+// which is much closer to what an actual apply would produce than reconstructing it
+// locally. Fields owned by a different fieldManager (an HPA, another controller) are
+// masked out of the diff, since they are provably out of scope for this signed manifest.
+func dryrunServerSideApplyMatch(kind, name, namespace string, objBytes, manifestBytes []byte, clusterScope, isCRD bool, dryRunNamespace string, normalizers []ResourceNormalizer, restConfig *rest.Config) (bool, *mapnode.DiffResult, error) {
+	objBytes, manifestBytes, err := applyNormalizers(kind, name, namespace, objBytes, manifestBytes, normalizers)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to normalize object/manifest")
+	}
+	objNode, err := mapnode.NewFromBytes(objBytes)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize object node")
+	}
+
+	namespace = objNode.GetString("metadata.namespace")
+	if clusterScope {
+		namespace = ""
+	} else if namespace == "" {
+		namespace = dryRunNamespace
+	}
+
+	mergedBytes, foreignOwnedFields, err := serverSideApplyDryRunForCluster(manifestBytes, namespace, ServerSideApplyFieldManager, restConfig)
+	if err != nil {
+		return false, nil, err
+	}
+	simNode, err := mapnode.NewFromYamlBytes(mergedBytes)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize server-side-apply-generated object node")
+	}
+
+	mask := []string{}
+	mask = append(mask, "metadata.name") // masked as in dryrunCreateMatch; the server-side apply dryrun keeps the manifest's name
+	if !clusterScope {
+		mask = append(mask, "metadata.namespace") // masked as in dryrunCreateMatch; the server-side apply dryrun runs in the live object's namespace
+	}
+	if isCRD {
+		mask = append(mask, "spec.names.kind")
+		mask = append(mask, "spec.names.listKind")
+		mask = append(mask, "spec.names.singular")
+		mask = append(mask, "spec.names.plural")
+	}
+	mask = append(mask, foreignOwnedFields...)
+
+	maskedObjNode := objNode.Mask(mask)
+	maskedSimNode := simNode.Mask(mask)
+	diff := maskedObjNode.Diff(maskedSimNode)
+	if diff == nil || diff.Size() == 0 {
+		return true, nil, nil
+	}
+	return false, diff, nil
+}
+
+// serverSideApplyDryRunForCluster routes the Server-Side Apply dry-run through restConfig
+// when set (multi-cluster verification), falling back to the ambient kubeconfig the same
+// way dryRunCreateForCluster/getApplyPatchBytesForCluster do otherwise: restConfig is
+// normally left nil by single-cluster VerifyResource callers (see
+// VerifyResourceOption.RestConfig), so UseServerSideApply needs this fallback to avoid
+// calling kubeutil.ServerSideApplyDryRun with a nil *rest.Config.
+func serverSideApplyDryRunForCluster(manifestBytes []byte, namespace, fieldManager string, restConfig *rest.Config) ([]byte, []string, error) {
+	if restConfig != nil {
+		return kubeutil.ServerSideApplyDryRun(restConfig, manifestBytes, namespace, fieldManager)
+	}
+	ambientConfig, err := kubeutil.RestConfigFromKubeconfig("", "")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to build ambient REST config for server-side apply dryrun")
+	}
+	return kubeutil.ServerSideApplyDryRun(ambientConfig, manifestBytes, namespace, fieldManager)
+}
@@ -28,19 +28,25 @@ import (
 
 	kubeutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
 	mapnode "github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+
+	"k8s.io/client-go/rest"
 )
 
 const defaultDryRunNamespace = "default"
 
 type VerifyResourceResult struct {
-	Verified        bool                   `json:"verified"`
-	InScope         bool                   `json:"inScope"`
-	Signer          string                 `json:"signer"`
-	SignedTime      *time.Time             `json:"signedTime"`
-	SigRef          string                 `json:"sigRef"`
-	Diff            *mapnode.DiffResult    `json:"diff"`
-	ContainerImages []kubeutil.ImageObject `json:"containerImages"`
-	Provenances     []*Provenance          `json:"provenances,omitempty"`
+	Verified              bool                   `json:"verified"`
+	InScope               bool                   `json:"inScope"`
+	Signer                string                 `json:"signer"`
+	SignedTime            *time.Time             `json:"signedTime"`
+	SigRef                string                 `json:"sigRef"`
+	Diff                  *mapnode.DiffResult    `json:"diff"`
+	ContainerImages       []kubeutil.ImageObject `json:"containerImages"`
+	Provenances           []*Provenance          `json:"provenances,omitempty"`
+	DecryptedFields       []string               `json:"decryptedFields,omitempty"`
+	Reasons               []VerifyFailureReason  `json:"reasons,omitempty"`
+	MatchedCandidateIndex int                    `json:"matchedCandidateIndex"`
+	CandidateCount        int                    `json:"candidateCount"`
 }
 
 func (r *VerifyResourceResult) String() string {
@@ -83,7 +89,7 @@ func VerifyResource(obj unstructured.Unstructured, vo *VerifyResourceOption) (*V
 	// check if the resource should be skipped or not
 	if vo != nil && len(vo.SkipObjects) > 0 {
 		if vo.SkipObjects.Match(obj) {
-			return &VerifyResourceResult{InScope: false}, nil
+			return &VerifyResourceResult{InScope: false, MatchedCandidateIndex: -1}, nil
 		}
 	}
 
@@ -99,9 +105,59 @@ func VerifyResource(obj unstructured.Unstructured, vo *VerifyResourceOption) (*V
 		}
 	}
 
+	// get per-resource compare-options (Argo-style) from the object's annotations
+	var compareOptionsAnnotationKey string
+	if vo == nil {
+		compareOptionsAnnotationKey = fmt.Sprintf("%s/%s", DefaultAnnotationKeyDomain, CompareOptionsAnnotationBaseName)
+	} else {
+		compareOptionsAnnotationKey = vo.AnnotationConfig.CompareOptionsAnnotationKey()
+	}
+	compareOptions := parseCompareOptions(obj.GetAnnotations()[compareOptionsAnnotationKey])
+
+	// built-in "known type" normalizers run for every resource, plus any caller-supplied ones
+	normalizers := defaultNormalizers()
+	if vo != nil {
+		normalizers = append(normalizers, vo.Normalizers...)
+	}
+
+	var restConfig *rest.Config
+	if vo != nil {
+		restConfig = vo.RestConfig
+	}
+
+	// decryptors run over each manifest candidate before it is diffed; the built-in SOPS
+	// decryptor is a no-op for manifests that aren't SOPS-encrypted
+	decryptors := []ManifestDecryptor{}
+	if vo != nil {
+		decryptors = append(decryptors, vo.Decryptors...)
+	}
+	decryptors = append(decryptors, NewSOPSDecryptor())
+
+	if imageRefString == "" && sigResourceRefString == "" {
+		return &VerifyResourceResult{
+			InScope:               inScope,
+			Reasons:               []VerifyFailureReason{newReason(ReasonCodeImageRefMissing, "no image reference found in VerifyResourceOption.ImageRef or the object's annotations")},
+			MatchedCandidateIndex: -1,
+		}, nil
+	}
+
 	var resourceManifests [][]byte
 	log.Debug("fetching manifest...")
-	resourceManifests, sigRef, err = NewManifestFetcher(imageRefString, sigResourceRefString, vo.AnnotationConfig, ignoreFields, vo.MaxResourceManifestNum).Fetch(objBytes)
+	if vo != nil && vo.manifestCache != nil {
+		// Only set by VerifyResourceMulti, scoped to that one fan-out call; a plain
+		// VerifyResource call always fetches fresh.
+		resourceManifests, sigRef, err = vo.manifestCache.fetch(imageRefString, sigResourceRefString, vo.AnnotationConfig, ignoreFields, vo.MaxResourceManifestNum, objBytes)
+	} else {
+		annotationConfig := AnnotationConfig{}
+		if vo != nil {
+			annotationConfig = vo.AnnotationConfig
+		}
+		maxResourceManifestNum := 0
+		if vo != nil {
+			maxResourceManifestNum = vo.MaxResourceManifestNum
+		}
+		resourceManifests, sigRef, err = NewManifestFetcher(imageRefString, sigResourceRefString, annotationConfig, ignoreFields, maxResourceManifestNum).Fetch(objBytes)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "YAML manifest not found for this resource")
 	}
@@ -109,15 +165,19 @@ func VerifyResource(obj unstructured.Unstructured, vo *VerifyResourceOption) (*V
 	var mnfMatched bool
 	var diff *mapnode.DiffResult
 	var diffsForAllCandidates []*mapnode.DiffResult
+	var decryptedFields []string
+	matchedCandidateIndex := -1
 	for i, candidate := range resourceManifests {
 		log.Debugf("try matching with the candidate %v out of %v", i+1, len(resourceManifests))
-		cndMatched, tmpDiff, err := matchResourceWithManifest(obj, candidate, ignoreFields, vo.DryRunNamespace, vo.CheckDryRunForApply)
+		cndMatched, tmpDiff, tmpDecryptedFields, err := matchResourceWithManifest(obj, candidate, ignoreFields, normalizers, compareOptions, decryptors, vo.DryRunNamespace, vo.CheckDryRunForApply, vo.UseServerSideApply, restConfig)
 		if err != nil {
 			return nil, errors.Wrap(err, "error occurred during matching manifest")
 		}
 		diffsForAllCandidates = append(diffsForAllCandidates, tmpDiff)
 		if cndMatched {
 			mnfMatched = true
+			decryptedFields = tmpDecryptedFields
+			matchedCandidateIndex = i
 			break
 		}
 	}
@@ -137,7 +197,22 @@ func VerifyResource(obj unstructured.Unstructured, vo *VerifyResourceOption) (*V
 		return nil, errors.Wrap(err, "failed to verify signature")
 	}
 
-	verified = mnfMatched && sigVerified && vo.Signers.Match(signerName)
+	signerAllowed := vo.Signers.Match(signerName)
+	verified = mnfMatched && sigVerified && signerAllowed
+
+	reasons := []VerifyFailureReason{}
+	if !mnfMatched {
+		if diff != nil {
+			reasons = append(reasons, diffFailureReasons(diff)...)
+		} else {
+			reasons = append(reasons, newReason(ReasonCodeManifestNotFound, "no manifest candidate matched the live object"))
+		}
+	}
+	if !sigVerified {
+		reasons = append(reasons, newReason(ReasonCodeSignatureInvalid, "signature verification failed"))
+	} else if !signerAllowed {
+		reasons = append(reasons, newReason(ReasonCodeSignerNotAllowed, fmt.Sprintf("signer %q is not an allowed signer", signerName)))
+	}
 
 	containerImages, err := kubeutil.GetAllImagesFromObject(&obj)
 	if err != nil {
@@ -153,18 +228,22 @@ func VerifyResource(obj unstructured.Unstructured, vo *VerifyResourceOption) (*V
 	}
 
 	return &VerifyResourceResult{
-		Verified:        verified,
-		InScope:         inScope,
-		Signer:          signerName,
-		SignedTime:      getTime(signedTimestamp),
-		SigRef:          sigRef,
-		Diff:            diff,
-		ContainerImages: containerImages,
-		Provenances:     provenances,
+		Verified:              verified,
+		InScope:               inScope,
+		Signer:                signerName,
+		SignedTime:            getTime(signedTimestamp),
+		SigRef:                sigRef,
+		Diff:                  diff,
+		ContainerImages:       containerImages,
+		Provenances:           provenances,
+		DecryptedFields:       decryptedFields,
+		Reasons:               reasons,
+		MatchedCandidateIndex: matchedCandidateIndex,
+		CandidateCount:        len(resourceManifests),
 	}, nil
 }
 
-func matchResourceWithManifest(obj unstructured.Unstructured, foundManifestBytes []byte, ignoreFields []string, dryRunNamespace string, checkDryRunForApply bool) (bool, *mapnode.DiffResult, error) {
+func matchResourceWithManifest(obj unstructured.Unstructured, foundManifestBytes []byte, ignoreFields []string, normalizers []ResourceNormalizer, compareOptions CompareOptions, decryptors []ManifestDecryptor, dryRunNamespace string, checkDryRunForApply, useServerSideApply bool, restConfig *rest.Config) (bool, *mapnode.DiffResult, []string, error) {
 
 	apiVersion := obj.GetAPIVersion()
 	kind := obj.GetKind()
@@ -182,16 +261,26 @@ func matchResourceWithManifest(obj unstructured.Unstructured, foundManifestBytes
 	log.Debug("obj: apiVersion", apiVersion, "kind", kind, "name", name)
 	log.Debug("manifest in image:", string(foundManifestBytes))
 
-	var err error
+	// the signature was already verified over the original (possibly encrypted) bytes
+	// pulled from the image; only what gets diffed against the live object changes here
+	foundManifestBytes, decryptedFields, err := decryptManifestCandidate(foundManifestBytes, decryptors)
+	if err != nil {
+		return false, nil, nil, errors.Wrap(err, "failed to decrypt manifest candidate")
+	}
+
 	var matched bool
 	var diff *mapnode.DiffResult
 	objBytes, _ := json.Marshal(obj.Object)
 
+	if compareOptions.IgnoreExtraneous {
+		ignoreFields = append(ignoreFields, extraneousIgnoreFields(objBytes, foundManifestBytes)...)
+	}
+
 	// CASE1: direct match
 	log.Debug("try direct matching")
-	matched, diff, err = directMatch(objBytes, foundManifestBytes)
+	matched, diff, err = directMatch(kind, name, namespace, objBytes, foundManifestBytes, normalizers)
 	if err != nil {
-		return false, nil, errors.Wrap(err, "error occured during diract match")
+		return false, nil, nil, errors.Wrap(err, "error occured during diract match")
 	}
 	if diff != nil && len(ignoreFields) > 0 {
 		_, diff, _ = diff.Filter(ignoreFields)
@@ -201,14 +290,14 @@ func matchResourceWithManifest(obj unstructured.Unstructured, foundManifestBytes
 		diff = nil
 	}
 	if matched {
-		return true, nil, nil
+		return true, nil, decryptedFields, nil
 	}
 
 	// CASE2: dryrun create match
 	log.Debug("try dryrun create matching")
-	matched, diff, err = dryrunCreateMatch(objBytes, foundManifestBytes, clusterScope, isCRD, dryRunNamespace)
+	matched, diff, err = dryrunCreateMatch(kind, name, namespace, objBytes, foundManifestBytes, clusterScope, isCRD, dryRunNamespace, normalizers, restConfig)
 	if err != nil {
-		return false, nil, errors.Wrap(err, "error occured during dryrun create match")
+		return false, nil, nil, errors.Wrap(err, "error occured during dryrun create match")
 	}
 	if diff != nil && len(ignoreFields) > 0 {
 		_, diff, _ = diff.Filter(ignoreFields)
@@ -218,15 +307,23 @@ func matchResourceWithManifest(obj unstructured.Unstructured, foundManifestBytes
 		diff = nil
 	}
 	if matched {
-		return true, nil, nil
+		return true, nil, decryptedFields, nil
 	}
 
 	// CASE3: dryrun apply match
 	if checkDryRunForApply {
 		log.Debug("try dryrun apply matching")
-		matched, diff, err = dryrunApplyMatch(objBytes, foundManifestBytes, clusterScope, isCRD, dryRunNamespace)
+		if useServerSideApply {
+			matched, diff, err = dryrunServerSideApplyMatch(kind, name, namespace, objBytes, foundManifestBytes, clusterScope, isCRD, dryRunNamespace, normalizers, restConfig)
+			if err != nil && kubeutil.IsServerSideApplyUnsupported(err) {
+				log.Debug("server-side apply not supported by this API server, falling back to client-side apply simulation")
+				matched, diff, err = dryrunApplyMatch(kind, name, namespace, objBytes, foundManifestBytes, clusterScope, isCRD, dryRunNamespace, normalizers, restConfig)
+			}
+		} else {
+			matched, diff, err = dryrunApplyMatch(kind, name, namespace, objBytes, foundManifestBytes, clusterScope, isCRD, dryRunNamespace, normalizers, restConfig)
+		}
 		if err != nil {
-			return false, nil, errors.Wrap(err, "error occured during dryrun apply match")
+			return false, nil, nil, errors.Wrap(err, "error occured during dryrun apply match")
 		}
 		if diff != nil && len(ignoreFields) > 0 {
 			_, diff, _ = diff.Filter(ignoreFields)
@@ -236,14 +333,18 @@ func matchResourceWithManifest(obj unstructured.Unstructured, foundManifestBytes
 			diff = nil
 		}
 		if matched {
-			return true, nil, nil
+			return true, nil, decryptedFields, nil
 		}
 	}
 
-	return false, diff, nil
+	return false, diff, decryptedFields, nil
 }
 
-func directMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, error) {
+func directMatch(kind, name, namespace string, objBytes, manifestBytes []byte, normalizers []ResourceNormalizer) (bool, *mapnode.DiffResult, error) {
+	objBytes, manifestBytes, err := applyNormalizers(kind, name, namespace, objBytes, manifestBytes, normalizers)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to normalize object/manifest")
+	}
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
@@ -259,7 +360,11 @@ func directMatch(objBytes, manifestBytes []byte) (bool, *mapnode.DiffResult, err
 	return false, diff, nil
 }
 
-func dryrunCreateMatch(objBytes, manifestBytes []byte, clusterScope, isCRD bool, dryRunNamespace string) (bool, *mapnode.DiffResult, error) {
+func dryrunCreateMatch(kind, name, namespace string, objBytes, manifestBytes []byte, clusterScope, isCRD bool, dryRunNamespace string, normalizers []ResourceNormalizer, restConfig *rest.Config) (bool, *mapnode.DiffResult, error) {
+	objBytes, manifestBytes, err := applyNormalizers(kind, name, namespace, objBytes, manifestBytes, normalizers)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to normalize object/manifest")
+	}
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
@@ -271,9 +376,9 @@ func dryrunCreateMatch(objBytes, manifestBytes []byte, clusterScope, isCRD bool,
 	nsMaskedManifestBytes := mnfNode.Mask([]string{"metadata.namespace"}).ToYaml()
 	var simBytes []byte
 	if clusterScope {
-		simBytes, err = kubeutil.DryRunCreate([]byte(nsMaskedManifestBytes), "")
+		simBytes, err = dryRunCreateForCluster([]byte(nsMaskedManifestBytes), "", restConfig)
 	} else {
-		simBytes, err = kubeutil.DryRunCreate([]byte(nsMaskedManifestBytes), dryRunNamespace)
+		simBytes, err = dryRunCreateForCluster([]byte(nsMaskedManifestBytes), dryRunNamespace, restConfig)
 	}
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to dryrun with the found YAML in image")
@@ -302,13 +407,17 @@ func dryrunCreateMatch(objBytes, manifestBytes []byte, clusterScope, isCRD bool,
 	return false, diff, nil
 }
 
-func dryrunApplyMatch(objBytes, manifestBytes []byte, clusterScope, isCRD bool, dryRunNamespace string) (bool, *mapnode.DiffResult, error) {
+func dryrunApplyMatch(kind, name, namespace string, objBytes, manifestBytes []byte, clusterScope, isCRD bool, dryRunNamespace string, normalizers []ResourceNormalizer, restConfig *rest.Config) (bool, *mapnode.DiffResult, error) {
+	objBytes, manifestBytes, err := applyNormalizers(kind, name, namespace, objBytes, manifestBytes, normalizers)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to normalize object/manifest")
+	}
 	objNode, err := mapnode.NewFromBytes(objBytes)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "failed to initialize object node")
 	}
 	objNamespace := objNode.GetString("metadata.namespace")
-	_, patchedBytes, err := kubeutil.GetApplyPatchBytes(manifestBytes, objNamespace)
+	_, patchedBytes, err := getApplyPatchBytesForCluster(manifestBytes, objNamespace, restConfig)
 	if err != nil {
 		return false, nil, errors.Wrap(err, "error during getting applied bytes")
 	}
@@ -316,9 +425,9 @@ func dryrunApplyMatch(objBytes, manifestBytes []byte, clusterScope, isCRD bool,
 	nsMaskedPatchedNode := patchedNode.Mask([]string{"metadata.namespace"})
 	var simPatchedObj []byte
 	if clusterScope {
-		simPatchedObj, err = kubeutil.DryRunCreate([]byte(nsMaskedPatchedNode.ToYaml()), "")
+		simPatchedObj, err = dryRunCreateForCluster([]byte(nsMaskedPatchedNode.ToYaml()), "", restConfig)
 	} else {
-		simPatchedObj, err = kubeutil.DryRunCreate([]byte(nsMaskedPatchedNode.ToYaml()), dryRunNamespace)
+		simPatchedObj, err = dryRunCreateForCluster([]byte(nsMaskedPatchedNode.ToYaml()), dryRunNamespace, restConfig)
 	}
 	if err != nil {
 		return false, nil, errors.Wrap(err, "error during DryRunCreate for apply")
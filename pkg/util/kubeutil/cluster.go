@@ -0,0 +1,102 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kubeutil
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// InClusterRestConfig returns the ambient in-cluster REST config, for use when this
+// process itself runs inside the cluster being verified.
+func InClusterRestConfig() (*rest.Config, error) {
+	return rest.InClusterConfig()
+}
+
+// RestConfigFromKubeconfig builds a REST config for one context of a kubeconfig file. An
+// empty kubeconfigPath falls back to the client-go default loading rules (the KUBECONFIG
+// env var, then ~/.kube/config); an empty context uses the kubeconfig's current-context.
+func RestConfigFromKubeconfig(kubeconfigPath, context string) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfigPath != "" {
+		loadingRules.ExplicitPath = kubeconfigPath
+	}
+	overrides := &clientcmd.ConfigOverrides{}
+	if context != "" {
+		overrides.CurrentContext = context
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+func resourceInterfaceForGVK(restConfig *rest.Config, apiVersion, kind, namespace string) (dynamic.ResourceInterface, error) {
+	gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create discovery client")
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get REST mapping")
+	}
+
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dynamic client")
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && namespace != "" {
+		return dyn.Resource(mapping.Resource).Namespace(namespace), nil
+	}
+	return dyn.Resource(mapping.Resource), nil
+}
+
+// GetResources lists the resources matching apiVersion/kind (optionally scoped to
+// namespace, a single name, and/or a label selector) from the cluster identified by
+// restConfig.
+func GetResources(restConfig *rest.Config, apiVersion, kind, namespace, name, labelSelector string) ([]unstructured.Unstructured, error) {
+	ri, err := resourceInterfaceForGVK(restConfig, apiVersion, kind, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		obj, err := ri.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get resource")
+		}
+		return []unstructured.Unstructured{*obj}, nil
+	}
+
+	list, err := ri.List(context.TODO(), metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list resources")
+	}
+	return list.Items, nil
+}
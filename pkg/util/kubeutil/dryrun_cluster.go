@@ -0,0 +1,100 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kubeutil
+
+import (
+	"context"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+)
+
+func decodeManifest(manifestBytes []byte) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(manifestBytes, &obj.Object); err != nil {
+		return nil, errors.Wrap(err, "failed to parse manifest")
+	}
+	return obj, nil
+}
+
+// DryRunCreateWithConfig simulates a Create of the manifest against the cluster
+// identified by restConfig, the same way DryRunCreate does against the ambient config.
+func DryRunCreateWithConfig(restConfig *rest.Config, manifestBytes []byte, namespace string) ([]byte, error) {
+	obj, err := decodeManifest(manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	ns := namespace
+	if ns == "" {
+		ns = obj.GetNamespace()
+	}
+	ri, err := resourceInterfaceForGVK(restConfig, obj.GetAPIVersion(), obj.GetKind(), ns)
+	if err != nil {
+		return nil, err
+	}
+
+	// renamed like `sample-configmap-dryrun` so the dry-run does not collide with the live object
+	obj.SetName(obj.GetName() + "-dryrun")
+	created, err := ri.Create(context.TODO(), obj, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dryrun-create resource")
+	}
+	return yaml.Marshal(created.Object)
+}
+
+// GetApplyPatchBytesWithConfig simulates a client-side apply against the cluster
+// identified by restConfig. The patch it returns is always nil; the patched object is the
+// live object with each top-level field of manifestBytes replacing the live one whole
+// (nested fields are not merged), or the manifest itself if the resource does not exist
+// yet.
+func GetApplyPatchBytesWithConfig(restConfig *rest.Config, manifestBytes []byte, namespace string) ([]byte, []byte, error) {
+	obj, err := decodeManifest(manifestBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	ns := namespace
+	if ns == "" {
+		ns = obj.GetNamespace()
+	}
+	ri, err := resourceInterfaceForGVK(restConfig, obj.GetAPIVersion(), obj.GetKind(), ns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current, err := ri.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		b, mErr := yaml.Marshal(obj.Object)
+		return nil, b, mErr
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get current resource for apply patch")
+	}
+
+	patched := current.DeepCopy()
+	for k, v := range obj.Object {
+		patched.Object[k] = v
+	}
+	patchedBytes, err := yaml.Marshal(patched.Object)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal patched resource")
+	}
+	return nil, patchedBytes, nil
+}
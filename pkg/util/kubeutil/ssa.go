@@ -0,0 +1,162 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kubeutil
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// ServerSideApplyDryRun issues a Server-Side Apply dry-run (PATCH,
+// application/apply-patch+yaml, force=false, dryRun=All) of manifestBytes against the
+// cluster identified by restConfig, under fieldManager. It returns the fully merged
+// object the API server computed (honoring defaulting, admission, and field ownership)
+// together with the dot-path fields (see foreignOwnedFields) owned by a fieldManager
+// other than fieldManager, which are provably out of scope for the manifest being applied.
+func ServerSideApplyDryRun(restConfig *rest.Config, manifestBytes []byte, namespace, fieldManager string) ([]byte, []string, error) {
+	obj, err := decodeManifest(manifestBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	ns := namespace
+	if ns == "" {
+		ns = obj.GetNamespace()
+	}
+	ri, err := resourceInterfaceForGVK(restConfig, obj.GetAPIVersion(), obj.GetKind(), ns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applyBytes, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal manifest for server-side apply")
+	}
+
+	force := false
+	merged, err := ri.Patch(context.TODO(), obj.GetName(), types.ApplyPatchType, applyBytes, metav1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        &force,
+		DryRun:       []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mergedBytes, err := yaml.Marshal(merged.Object)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal server-side apply result")
+	}
+	return mergedBytes, foreignOwnedFields(merged, fieldManager), nil
+}
+
+// foreignOwnedFields returns the dot-path fields of obj's metadata.managedFields that
+// are owned by a fieldManager other than ours (e.g. an HPA owning spec.replicas, or
+// another controller populating status.loadBalancer), expanded down to the actual leaf
+// paths so that masking one doesn't also mask unrelated siblings under the same
+// top-level key.
+func foreignOwnedFields(obj *unstructured.Unstructured, fieldManager string) []string {
+	managedFields, found, err := unstructured.NestedSlice(obj.Object, "metadata", "managedFields")
+	if err != nil || !found {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, mf := range managedFields {
+		entry, ok := mf.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if manager, _ := entry["manager"].(string); manager == fieldManager {
+			continue
+		}
+		fieldsV1, ok := entry["fieldsV1"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		collectFieldsV1Paths(fieldsV1, "", seen)
+	}
+
+	fields := make([]string, 0, len(seen))
+	for k := range seen {
+		fields = append(fields, k)
+	}
+	return fields
+}
+
+// collectFieldsV1Paths walks a managedFields "fieldsV1" structure and records every leaf
+// field it owns as a dot-path in seen. fieldsV1 encodes ownership as nested objects keyed
+// "f:<name>" (a field), "k:<json>" (a list element keyed by its identifying fields), or
+// "v:<json>" (a scalar list element); "." marks ownership of the key's own value rather
+// than a child. See https://git.k8s.io/enhancements/keps/sig-api-machinery/555-server-side-apply
+func collectFieldsV1Paths(fieldsV1 map[string]interface{}, prefix string, seen map[string]bool) {
+	for k, v := range fieldsV1 {
+		if k == "." {
+			if prefix != "" {
+				seen[prefix] = true
+			}
+			continue
+		}
+		if !strings.HasPrefix(k, "f:") {
+			// "k:" / "v:" list-element selectors aren't expressible as a dot-path in
+			// this package's addressing scheme; fall back to masking the list itself.
+			if prefix != "" {
+				seen[prefix] = true
+			}
+			continue
+		}
+		name := strings.TrimPrefix(k, "f:")
+		childPrefix := name
+		if prefix != "" {
+			childPrefix = prefix + "." + name
+		}
+		child, ok := v.(map[string]interface{})
+		if !ok || len(child) == 0 {
+			seen[childPrefix] = true
+			continue
+		}
+		collectFieldsV1Paths(child, childPrefix, seen)
+	}
+}
+
+// IsServerSideApplyUnsupported reports whether err indicates the API server rejected the
+// Server-Side Apply request outright (e.g. an old API server that doesn't recognize the
+// apply-patch content type), as opposed to a normal validation/conflict error that a
+// retry wouldn't fix by falling back to the client-side simulation.
+func IsServerSideApplyUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok {
+		return false
+	}
+	switch statusErr.ErrStatus.Code {
+	case http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
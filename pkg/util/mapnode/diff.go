@@ -0,0 +1,164 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mapnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffElement is one leaf-level difference found between two Nodes. Before is the value
+// on the receiver Node.Diff was called on; After is the value on the Node passed to it.
+type DiffElement struct {
+	Key    string `json:"key"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// DiffResult is every leaf-level difference between two Nodes, in the order of a
+// depth-first walk: map keys in sorted order, list elements by index.
+type DiffResult struct {
+	Items []*DiffElement `json:"items"`
+}
+
+// Size returns the number of differing fields.
+func (d *DiffResult) Size() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.Items)
+}
+
+// String renders the diff as one "key: before -> after" line per field, for logging and
+// as the fallback summary when a caller doesn't need per-field detail.
+func (d *DiffResult) String() string {
+	if d == nil {
+		return ""
+	}
+	lines := make([]string, 0, len(d.Items))
+	for _, item := range d.Items {
+		lines = append(lines, fmt.Sprintf("%s: %s -> %s", item.Key, item.Before, item.After))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Filter drops every DiffElement whose Key is in fields or lies under one of them (e.g.
+// "status" also drops "status.phase" and "status[0]"), returning whether any differences
+// remain along with the filtered result (nil if none do).
+func (d *DiffResult) Filter(fields []string) (bool, *DiffResult, error) {
+	if d == nil {
+		return false, nil, nil
+	}
+	ignored := func(key string) bool {
+		for _, f := range fields {
+			if key == f || strings.HasPrefix(key, f+".") || strings.HasPrefix(key, f+"[") {
+				return true
+			}
+		}
+		return false
+	}
+	items := make([]*DiffElement, 0, len(d.Items))
+	for _, item := range d.Items {
+		if ignored(item.Key) {
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return false, nil, nil
+	}
+	return true, &DiffResult{Items: items}, nil
+}
+
+func diffValues(prefix string, before, after interface{}) []*DiffElement {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+
+	// A missing side (before==nil or after==nil, e.g. a field only one of the two
+	// documents has, or an admission webhook adding a whole sub-object) is treated as an
+	// empty map/slice rather than compared whole, so the result still has one leaf
+	// DiffElement per descendant field instead of one giant stringified blob.
+	if (beforeIsMap && afterIsMap) || (beforeIsMap && after == nil) || (afterIsMap && before == nil) {
+		keys := make(map[string]bool, len(beforeMap)+len(afterMap))
+		for k := range beforeMap {
+			keys[k] = true
+		}
+		for k := range afterMap {
+			keys[k] = true
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for k := range keys {
+			sortedKeys = append(sortedKeys, k)
+		}
+		sort.Strings(sortedKeys)
+
+		var items []*DiffElement
+		for _, k := range sortedKeys {
+			childPrefix := k
+			if prefix != "" {
+				childPrefix = prefix + "." + k
+			}
+			items = append(items, diffValues(childPrefix, beforeMap[k], afterMap[k])...)
+		}
+		return items
+	}
+
+	beforeSlice, beforeIsSlice := before.([]interface{})
+	afterSlice, afterIsSlice := after.([]interface{})
+
+	if (beforeIsSlice && afterIsSlice) || (beforeIsSlice && after == nil) || (afterIsSlice && before == nil) {
+		length := len(beforeSlice)
+		if len(afterSlice) > length {
+			length = len(afterSlice)
+		}
+
+		var items []*DiffElement
+		for i := 0; i < length; i++ {
+			childPrefix := fmt.Sprintf("%s[%d]", prefix, i)
+			var beforeElem, afterElem interface{}
+			if i < len(beforeSlice) {
+				beforeElem = beforeSlice[i]
+			}
+			if i < len(afterSlice) {
+				afterElem = afterSlice[i]
+			}
+			items = append(items, diffValues(childPrefix, beforeElem, afterElem)...)
+		}
+		return items
+	}
+
+	if stringify(before) == stringify(after) {
+		return nil
+	}
+	return []*DiffElement{{Key: prefix, Before: stringify(before), After: stringify(after)}}
+}
+
+func stringify(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
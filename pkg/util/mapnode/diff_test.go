@@ -0,0 +1,83 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package mapnode
+
+import (
+	"sort"
+	"testing"
+)
+
+func keysOf(d *DiffResult) []string {
+	keys := make([]string, 0, d.Size())
+	for _, item := range d.Items {
+		keys = append(keys, item.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDiffArrayElement(t *testing.T) {
+	before, err := NewFromBytes([]byte(`{"spec":{"containers":[{"name":"app","image":"v1"},{"name":"sidecar","image":"v1"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewFromBytes([]byte(`{"spec":{"containers":[{"name":"app","image":"v2"},{"name":"sidecar","image":"v1"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := before.Diff(after)
+	if diff.Size() != 1 {
+		t.Fatalf("Diff() size = %d, want 1 (got %v)", diff.Size(), keysOf(diff))
+	}
+	item := diff.Items[0]
+	if item.Key != "spec.containers[0].image" {
+		t.Errorf("Key = %q, want %q", item.Key, "spec.containers[0].image")
+	}
+	if item.Before != "v1" || item.After != "v2" {
+		t.Errorf("Before/After = %q/%q, want v1/v2", item.Before, item.After)
+	}
+}
+
+func TestDiffArrayLengthChange(t *testing.T) {
+	before, err := NewFromBytes([]byte(`{"spec":{"containers":[{"name":"app"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewFromBytes([]byte(`{"spec":{"containers":[{"name":"app"},{"name":"sidecar"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := before.Diff(after)
+	want := []string{"spec.containers[1].name"}
+	if got := keysOf(diff); !equalStrings(got, want) {
+		t.Errorf("Diff() keys = %v, want %v", got, want)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
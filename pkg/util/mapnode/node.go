@@ -0,0 +1,144 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package mapnode provides a dot-path addressable view over a decoded JSON/YAML
+// document (a Kubernetes manifest, typically), used by k8smanifest to mask fields before
+// comparison and to diff two manifests field-by-field.
+package mapnode
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Node is an in-memory document addressable by dot-notation paths, e.g.
+// "metadata.namespace" or "spec.template.metadata.labels".
+type Node struct {
+	value interface{}
+}
+
+// NewFromBytes decodes JSON-encoded raw into a Node.
+func NewFromBytes(raw []byte) (*Node, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &Node{value: v}, nil
+}
+
+// NewFromYamlBytes decodes YAML-encoded raw into a Node.
+func NewFromYamlBytes(raw []byte) (*Node, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &Node{value: v}, nil
+}
+
+// ToYaml renders this Node back out as YAML.
+func (n *Node) ToYaml() string {
+	if n == nil {
+		return ""
+	}
+	b, err := yaml.Marshal(n.value)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// GetString returns the string at path, or "" if path doesn't resolve to a string.
+func (n *Node) GetString(path string) string {
+	if n == nil {
+		return ""
+	}
+	s, _ := getPath(n.value, splitPath(path)).(string)
+	return s
+}
+
+// Mask returns a copy of this Node with every field in fields removed, so it no longer
+// participates in Diff.
+func (n *Node) Mask(fields []string) *Node {
+	if n == nil {
+		return nil
+	}
+	masked := deepCopy(n.value)
+	for _, f := range fields {
+		maskPath(masked, splitPath(f))
+	}
+	return &Node{value: masked}
+}
+
+// Diff compares this Node (the "before" side) against other (the "after" side) and
+// returns every leaf path at which they differ, or nil if they're equivalent.
+func (n *Node) Diff(other *Node) *DiffResult {
+	var before, after interface{}
+	if n != nil {
+		before = n.value
+	}
+	if other != nil {
+		after = other.value
+	}
+	items := diffValues("", before, after)
+	if len(items) == 0 {
+		return nil
+	}
+	return &DiffResult{Items: items}
+}
+
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func getPath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return v
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return getPath(m[path[0]], path[1:])
+}
+
+func maskPath(v interface{}, path []string) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(path) == 0 {
+		return
+	}
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	maskPath(m[path[0]], path[1:])
+}
+
+func deepCopy(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}